@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// fileChunkSize is the amount of file data carried by each fileFrame.
+const fileChunkSize = 32 * 1024
+
+// fileFrame is one newline-delimited JSON object in the file-transfer
+// protocol carried over a stream data channel opened with OpenStream.
+// The first frame sent by either side is a header (Name/Size/SHA256
+// set, Chunk nil); every frame after that carries a chunk at Offset.
+type fileFrame struct {
+	FileID string `json:"fileId"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Offset int64  `json:"offset"`
+	Chunk  []byte `json:"chunk,omitempty"`
+}
+
+// resumeState is the sidecar file a receiver keeps next to a
+// partially-written destination so a re-run of recvfile can continue
+// from the last acknowledged offset instead of restarting.
+type resumeState struct {
+	SHA256 string `json:"sha256"`
+	Offset int64  `json:"offset"`
+}
+
+// SendFile streams path to peerID over a new stream data channel,
+// resuming from whatever offset the receiver reports it already has.
+// fileID is the file's own sha256, which also doubles as the resume
+// key.
+func (c *Client) SendFile(peerID, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	sum, err := sha256File(f)
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.OpenStream(peerID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	header := fileFrame{FileID: sum, Name: filepath.Base(path), Size: info.Size(), SHA256: sum}
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("sending file header: %w", err)
+	}
+
+	var resume fileFrame
+	if err := json.NewDecoder(conn).Decode(&resume); err != nil {
+		return fmt.Errorf("reading resume offset: %w", err)
+	}
+
+	if _, err := f.Seek(resume.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	wc, _ := conn.(*webrtcConn)
+	buf := make([]byte, fileChunkSize)
+	sent := resume.Offset
+	for {
+		n, readErr := f.Read(buf)
+		if n > 0 {
+			if wc != nil {
+				wc.throttle()
+			}
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			if err := enc.Encode(fileFrame{FileID: sum, Offset: sent, Chunk: chunk}); err != nil {
+				return fmt.Errorf("sending chunk at offset %d: %w", sent, err)
+			}
+			sent += int64(n)
+			printFileProgress(path, sent, info.Size())
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	fmt.Println()
+
+	return nil
+}
+
+// ExpectFile arms the client to save the next stream data channel
+// opened by peerID to path, used by the recvfile CLI command.
+func (c *Client) ExpectFile(peerID, path string) {
+	c.mu.Lock()
+	c.recvTargets[peerID] = path
+	c.mu.Unlock()
+}
+
+// handleIncomingFile is registered as the client's OnStream callback
+// and implements the receiving half of the file-transfer protocol.
+func (c *Client) handleIncomingFile(peerID string, conn net.Conn) {
+	defer conn.Close()
+
+	dec := json.NewDecoder(conn)
+	var header fileFrame
+	if err := dec.Decode(&header); err != nil {
+		log.Println("Error reading file header from", peerID, ":", err)
+		return
+	}
+
+	c.mu.Lock()
+	path, ok := c.recvTargets[peerID]
+	delete(c.recvTargets, peerID)
+	c.mu.Unlock()
+	if !ok {
+		// OnStream is registered for every peer, including ones we never
+		// ran recvfile for (e.g. a mesh peer from a shared room), so we
+		// can't trust header.Name as a destination path here: it's
+		// attacker-controlled and writing to it would let any connected
+		// peer overwrite an arbitrary file of our choosing. Refuse the
+		// transfer instead.
+		log.Printf("Rejecting unexpected file transfer from %s (no recvfile target armed)", peerID)
+		return
+	}
+
+	statePath := path + ".resume"
+	offset := readResumeOffset(statePath, header.SHA256)
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset > 0 {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		log.Println("Error opening destination file:", err)
+		return
+	}
+	defer out.Close()
+
+	if err := json.NewEncoder(conn).Encode(fileFrame{FileID: header.FileID, Offset: offset}); err != nil {
+		log.Println("Error sending resume offset:", err)
+		return
+	}
+
+	received := offset
+	for received < header.Size {
+		var frame fileFrame
+		if err := dec.Decode(&frame); err != nil {
+			log.Println("Error reading file chunk from", peerID, ":", err)
+			return
+		}
+		if _, err := out.Write(frame.Chunk); err != nil {
+			log.Println("Error writing file chunk:", err)
+			return
+		}
+		received += int64(len(frame.Chunk))
+		writeResumeState(statePath, header.SHA256, received)
+		printFileProgress(path, received, header.Size)
+	}
+	fmt.Println()
+
+	if err := out.Close(); err != nil {
+		log.Println("Error closing destination file:", err)
+		return
+	}
+	if err := verifyFileChecksum(path, header.SHA256); err != nil {
+		log.Println("Checksum verification failed:", err)
+		return
+	}
+	os.Remove(statePath)
+	fmt.Printf("Received %s from %s (%d bytes, checksum OK)\n", path, peerID, received)
+}
+
+// readResumeOffset returns the offset a previous, interrupted transfer
+// of the same file (matched by sha256) left off at, or 0 if there's no
+// usable resume state.
+func readResumeOffset(statePath, sha string) int64 {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return 0
+	}
+
+	var state resumeState
+	if err := json.Unmarshal(data, &state); err != nil || state.SHA256 != sha {
+		return 0
+	}
+	return state.Offset
+}
+
+func writeResumeState(statePath, sha string, offset int64) {
+	data, err := json.Marshal(resumeState{SHA256: sha, Offset: offset})
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		log.Println("Error writing resume state:", err)
+	}
+}
+
+func verifyFileChecksum(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	got, err := sha256File(f)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+func sha256File(f *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func printFileProgress(path string, done, total int64) {
+	fmt.Printf("\r%s: %d/%d bytes (%.1f%%)", filepath.Base(path), done, total, float64(done)/float64(total)*100)
+}
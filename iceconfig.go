@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ICEServerConfig mirrors one entry of the "iceServers" array in an
+// --ice-config file, e.g. Galène's ice.json:
+//
+//	{"urls": ["turn:turn.example.com:3478"], "username": "u", "credential": "p", "credentialType": "password"}
+type ICEServerConfig struct {
+	URLs           []string `json:"urls"`
+	Username       string   `json:"username,omitempty"`
+	Credential     string   `json:"credential,omitempty"`
+	CredentialType string   `json:"credentialType,omitempty"`
+}
+
+// ICEConfig is the top-level shape of the --ice-config file.
+type ICEConfig struct {
+	ICEServers []ICEServerConfig `json:"iceServers"`
+}
+
+// defaultICEConfig is used when no --ice-config file is given, or it
+// can't be read or parsed, so the client can still traverse simple NATs.
+func defaultICEConfig() webrtc.Configuration {
+	return webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{URLs: []string{"stun:stun.l.google.com:19302"}},
+		},
+	}
+}
+
+// loadICEConfig reads and parses an ICE server list from path. If path
+// is empty or the file can't be read or parsed, it logs and falls back
+// to defaultICEConfig rather than failing startup.
+func loadICEConfig(path string) webrtc.Configuration {
+	if path == "" {
+		return defaultICEConfig()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Could not read ICE config %q, using defaults: %v", path, err)
+		return defaultICEConfig()
+	}
+
+	var cfg ICEConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Printf("Could not parse ICE config %q, using defaults: %v", path, err)
+		return defaultICEConfig()
+	}
+
+	servers := make([]webrtc.ICEServer, 0, len(cfg.ICEServers))
+	for _, s := range cfg.ICEServers {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           s.URLs,
+			Username:       s.Username,
+			Credential:     s.Credential,
+			CredentialType: parseICECredentialType(s.CredentialType),
+		})
+	}
+
+	return webrtc.Configuration{ICEServers: servers}
+}
+
+// parseICECredentialType decodes a "password"/"oauth" credentialType
+// string into webrtc.ICECredentialType, which is int-backed and so
+// can't be produced with a plain conversion. It defers to the type's
+// own UnmarshalJSON, which already understands this string form.
+// Unknown or empty values default to ICECredentialTypePassword.
+func parseICECredentialType(s string) webrtc.ICECredentialType {
+	var credentialType webrtc.ICECredentialType
+	if s == "" {
+		return credentialType
+	}
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return credentialType
+	}
+	if err := credentialType.UnmarshalJSON(raw); err != nil {
+		log.Printf("Unknown ICE credentialType %q, defaulting to password", s)
+		return webrtc.ICECredentialTypePassword
+	}
+	return credentialType
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// setupLifecycle wires a peer connection's state-change events to
+// teardownPeer so a failed, disconnected or closed connection is
+// always reflected in our bookkeeping, and its resources are always
+// released, without the CLI having to notice.
+func (c *Client) setupLifecycle(peerConnection *webrtc.PeerConnection, peerID string) {
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		log.Printf("ICE connection state with %s: %s", peerID, state)
+		switch state {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateClosed:
+			c.teardownPeer(peerID)
+		}
+	})
+
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("Connection state with %s: %s", peerID, state)
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected, webrtc.PeerConnectionStateClosed:
+			c.teardownPeer(peerID)
+		}
+	})
+}
+
+// teardownPeer forgets everything we track about peerID and closes its
+// peer connection. Safe to call more than once for the same peer.
+func (c *Client) teardownPeer(peerID string) {
+	c.mu.Lock()
+	peerConnection, ok := c.peerConns[peerID]
+	delete(c.peerConns, peerID)
+	delete(c.dataChannels, peerID)
+	delete(c.streams, peerID)
+	delete(c.streamCounts, peerID)
+	delete(c.pendingCandidates, peerID)
+	c.mu.Unlock()
+
+	if ok {
+		peerConnection.Close()
+	}
+}
+
+// Close tears down every peer connection and the signaling transport.
+// The client must not be used afterwards.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	peerConns := make([]*webrtc.PeerConnection, 0, len(c.peerConns))
+	for _, peerConnection := range c.peerConns {
+		peerConns = append(peerConns, peerConnection)
+	}
+	c.peerConns = make(map[string]*webrtc.PeerConnection)
+	c.dataChannels = make(map[string]*webrtc.DataChannel)
+	c.streams = make(map[string]net.Conn)
+	c.streamCounts = make(map[string]int)
+	c.pendingCandidates = make(map[string][]webrtc.ICECandidateInit)
+	c.connecting = make(map[string]struct{})
+	c.mu.Unlock()
+
+	for _, peerConnection := range peerConns {
+		peerConnection.Close()
+	}
+
+	return c.signaling.Close()
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strings"
+	"sync"
 
-	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -18,22 +20,46 @@ type Message struct {
 }
 
 type Client struct {
-	conn         *websocket.Conn
-	peerID       string
-	peerConns    map[string]*webrtc.PeerConnection
-	dataChannels map[string]*webrtc.DataChannel
+	signaling Signaling
+	peerID    string
+	iceConfig webrtc.Configuration
+	api       *webrtc.API
+
+	// mu guards every field below, all of which are read or written from
+	// the signaling reader goroutine, pion's own callback goroutines, and
+	// the main CLI goroutine.
+	mu                sync.RWMutex
+	peerConns         map[string]*webrtc.PeerConnection
+	dataChannels      map[string]*webrtc.DataChannel
+	streams           map[string]net.Conn
+	streamCounts      map[string]int
+	pendingCandidates map[string][]webrtc.ICECandidateInit
+	onStream          func(peerID string, conn net.Conn)
+	recvTargets       map[string]string
+	rooms             map[string]map[string]struct{}
+	connecting        map[string]struct{}
 }
 
-func NewClient(url string) (*Client, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return nil, err
-	}
+func NewClient(signaling Signaling, iceConfig webrtc.Configuration) (*Client, error) {
+	// Detaching data channels (rather than using the OnMessage/SendText
+	// convenience API) lets us expose them as plain net.Conns, following
+	// the pattern anacrolix/torrent uses for its webtorrent transport.
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.DetachDataChannels()
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
 
 	return &Client{
-		conn:         conn,
-		peerConns:    make(map[string]*webrtc.PeerConnection),
-		dataChannels: make(map[string]*webrtc.DataChannel),
+		signaling:         signaling,
+		iceConfig:         iceConfig,
+		api:               api,
+		peerConns:         make(map[string]*webrtc.PeerConnection),
+		dataChannels:      make(map[string]*webrtc.DataChannel),
+		streams:           make(map[string]net.Conn),
+		streamCounts:      make(map[string]int),
+		pendingCandidates: make(map[string][]webrtc.ICECandidateInit),
+		recvTargets:       make(map[string]string),
+		rooms:             make(map[string]map[string]struct{}),
+		connecting:        make(map[string]struct{}),
 	}, nil
 }
 
@@ -42,7 +68,7 @@ func (c *Client) Register() {
 		Type: "register",
 	}
 	log.Println("Sending register message")
-	err := c.conn.WriteJSON(msg)
+	err := c.signaling.Send(msg)
 	if err != nil {
 		log.Println("Error sending register message:", err)
 		return
@@ -51,7 +77,7 @@ func (c *Client) Register() {
 }
 
 func (c *Client) ConnectToPeer(peerID string) error {
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	peerConnection, err := c.api.NewPeerConnection(c.iceConfig)
 	if err != nil {
 		return err
 	}
@@ -62,6 +88,8 @@ func (c *Client) ConnectToPeer(peerID string) error {
 	}
 
 	c.setupDataChannel(dataChannel, peerID)
+	c.setupICECandidateForwarding(peerConnection, peerID)
+	c.setupLifecycle(peerConnection, peerID)
 
 	offer, err := peerConnection.CreateOffer(nil)
 	if err != nil {
@@ -73,47 +101,128 @@ func (c *Client) ConnectToPeer(peerID string) error {
 		return err
 	}
 
+	c.mu.Lock()
 	c.peerConns[peerID] = peerConnection
+	c.mu.Unlock()
+
+	payload, err := json.Marshal(struct {
+		Target string                    `json:"target"`
+		Offer  webrtc.SessionDescription `json:"offer"`
+	}{Target: peerID, Offer: offer})
+	if err != nil {
+		return err
+	}
 
 	offerMsg := Message{
 		Type:    "offer",
-		Payload: json.RawMessage(fmt.Sprintf(`{"target":"%s","offer":%s}`, peerID, offer.SDP)),
+		Payload: payload,
 	}
-	c.conn.WriteJSON(offerMsg)
+	c.signaling.Send(offerMsg)
 
 	return nil
 }
 
+// setupICECandidateForwarding forwards every locally gathered ICE
+// candidate to peerID over the signaling channel as it's discovered,
+// rather than waiting for gathering to complete.
+func (c *Client) setupICECandidateForwarding(peerConnection *webrtc.PeerConnection, peerID string) {
+	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+
+		payload, err := json.Marshal(struct {
+			Target    string                  `json:"target"`
+			Candidate webrtc.ICECandidateInit `json:"candidate"`
+		}{Target: peerID, Candidate: candidate.ToJSON()})
+		if err != nil {
+			log.Println("Error marshalling ICE candidate:", err)
+			return
+		}
+
+		err = c.signaling.Send(Message{Type: "ice-candidate", Payload: payload})
+		if err != nil {
+			log.Println("Error sending ICE candidate:", err)
+		}
+	})
+}
+
+// drainPendingCandidates adds any ICE candidates that arrived from
+// peerID before its remote description was set, then forgets them.
+func (c *Client) drainPendingCandidates(peerConnection *webrtc.PeerConnection, peerID string) {
+	c.mu.Lock()
+	candidates := c.pendingCandidates[peerID]
+	delete(c.pendingCandidates, peerID)
+	c.mu.Unlock()
+
+	for _, candidate := range candidates {
+		if err := peerConnection.AddICECandidate(candidate); err != nil {
+			log.Println("Error adding buffered ICE candidate:", err)
+		}
+	}
+}
+
 func (c *Client) SendMessage(peerID, message string) {
-	if dataChannel, ok := c.dataChannels[peerID]; ok {
-		dataChannel.SendText(message)
-		fmt.Printf("Message sent to %s: %s\n", peerID, message)
-	} else {
+	c.mu.RLock()
+	stream, ok := c.streams[peerID]
+	c.mu.RUnlock()
+	if !ok {
 		fmt.Printf("No open data channel to peer %s\n", peerID)
+		return
 	}
+
+	if _, err := stream.Write([]byte(message + "\n")); err != nil {
+		log.Println("Error sending message:", err)
+		return
+	}
+	fmt.Printf("Message sent to %s: %s\n", peerID, message)
 }
 
+// setupDataChannel wires up the "data" control channel used for plain
+// text chat. The channel is detached (see NewClient) so text is read
+// back as newline-delimited lines rather than via OnMessage.
+//
+// The channel is registered in dataChannels immediately, not inside
+// OnOpen: registering it only once the channel opens used to race with
+// a "send" typed in right after "connect", before OnOpen had fired.
 func (c *Client) setupDataChannel(dataChannel *webrtc.DataChannel, peerID string) {
+	c.mu.Lock()
+	c.dataChannels[peerID] = dataChannel
+	c.mu.Unlock()
+
 	dataChannel.OnOpen(func() {
 		fmt.Printf("Data channel opened with peer: %s\n", peerID)
-		c.dataChannels[peerID] = dataChannel
-	})
 
-	dataChannel.OnMessage(func(msg webrtc.DataChannelMessage) {
-		fmt.Printf("Received message from %s: %s\n", peerID, string(msg.Data))
+		raw, err := dataChannel.Detach()
+		if err != nil {
+			log.Println("Error detaching data channel:", err)
+			return
+		}
+
+		conn := c.wrapStream(raw, dataChannel, peerID)
+
+		c.mu.Lock()
+		c.streams[peerID] = conn
+		c.mu.Unlock()
+
+		go c.readTextMessages(conn, peerID)
 	})
 }
 
+// readTextMessages prints newline-delimited text messages as they
+// arrive on a detached "data" channel.
+func (c *Client) readTextMessages(conn net.Conn, peerID string) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fmt.Printf("Received message from %s: %s\n", peerID, scanner.Text())
+	}
+}
+
 func (c *Client) handleIncomingMessages() {
 	for {
-		var msg Message
-		err := c.conn.ReadJSON(&msg)
+		msg, err := c.signaling.Recv()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Println("WebSocket connection closed unexpectedly:", err)
-			} else {
-				log.Println("Error reading message:", err)
-			}
+			log.Println("Error reading message:", err)
 			return
 		}
 
@@ -161,20 +270,50 @@ func (c *Client) handleIncomingMessages() {
 			}
 			json.Unmarshal(msg.Payload, &payload)
 			c.handleICECandidate(payload.Candidate, payload.Source)
+
+		case "joined":
+			var payload struct {
+				Room    string   `json:"room"`
+				Members []string `json:"members"`
+			}
+			json.Unmarshal(msg.Payload, &payload)
+			c.handleJoined(payload.Room, payload.Members)
+
+		case "peer-joined":
+			var payload struct {
+				Room   string `json:"room"`
+				PeerID string `json:"peerId"`
+			}
+			json.Unmarshal(msg.Payload, &payload)
+			c.handlePeerJoined(payload.Room, payload.PeerID)
+
+		case "peer-left":
+			var payload struct {
+				Room   string `json:"room"`
+				PeerID string `json:"peerId"`
+			}
+			json.Unmarshal(msg.Payload, &payload)
+			c.handlePeerLeft(payload.Room, payload.PeerID)
 		}
 	}
 }
 
 func (c *Client) handleOffer(offer webrtc.SessionDescription, sourcePeerID string) {
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	peerConnection, err := c.api.NewPeerConnection(c.iceConfig)
 	if err != nil {
 		log.Println("Error creating peer connection:", err)
 		return
 	}
 
 	peerConnection.OnDataChannel(func(dataChannel *webrtc.DataChannel) {
-		c.setupDataChannel(dataChannel, sourcePeerID)
+		if dataChannel.Label() == "data" {
+			c.setupDataChannel(dataChannel, sourcePeerID)
+			return
+		}
+		c.setupIncomingStream(dataChannel, sourcePeerID)
 	})
+	c.setupICECandidateForwarding(peerConnection, sourcePeerID)
+	c.setupLifecycle(peerConnection, sourcePeerID)
 
 	err = peerConnection.SetRemoteDescription(offer)
 	if err != nil {
@@ -182,6 +321,11 @@ func (c *Client) handleOffer(offer webrtc.SessionDescription, sourcePeerID strin
 		return
 	}
 
+	c.mu.Lock()
+	c.peerConns[sourcePeerID] = peerConnection
+	c.mu.Unlock()
+	c.drainPendingCandidates(peerConnection, sourcePeerID)
+
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
 		log.Println("Error creating answer:", err)
@@ -194,37 +338,71 @@ func (c *Client) handleOffer(offer webrtc.SessionDescription, sourcePeerID strin
 		return
 	}
 
-	c.peerConns[sourcePeerID] = peerConnection
-
-	answerMsg := Message{
-		Type:    "answer",
-		Payload: json.RawMessage(fmt.Sprintf(`{"target":"%s","answer":%s}`, sourcePeerID, answer.SDP)),
+	payload, err := json.Marshal(struct {
+		Target string                    `json:"target"`
+		Answer webrtc.SessionDescription `json:"answer"`
+	}{Target: sourcePeerID, Answer: answer})
+	if err != nil {
+		log.Println("Error marshalling answer:", err)
+		return
 	}
-	c.conn.WriteJSON(answerMsg)
+
+	c.signaling.Send(Message{Type: "answer", Payload: payload})
 }
 
 func (c *Client) handleAnswer(answer webrtc.SessionDescription, sourcePeerID string) {
-	if peerConnection, ok := c.peerConns[sourcePeerID]; ok {
-		peerConnection.SetRemoteDescription(answer)
+	c.mu.RLock()
+	peerConnection, ok := c.peerConns[sourcePeerID]
+	c.mu.RUnlock()
+	if !ok {
+		return
 	}
+
+	if err := peerConnection.SetRemoteDescription(answer); err != nil {
+		log.Println("Error setting remote description from answer:", err)
+		return
+	}
+
+	c.drainPendingCandidates(peerConnection, sourcePeerID)
 }
 
 func (c *Client) handleICECandidate(candidate webrtc.ICECandidateInit, sourcePeerID string) {
-	if peerConnection, ok := c.peerConns[sourcePeerID]; ok {
-		peerConnection.AddICECandidate(candidate)
+	c.mu.Lock()
+	peerConnection, ok := c.peerConns[sourcePeerID]
+	if !ok || peerConnection.RemoteDescription() == nil {
+		c.pendingCandidates[sourcePeerID] = append(c.pendingCandidates[sourcePeerID], candidate)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	if err := peerConnection.AddICECandidate(candidate); err != nil {
+		log.Println("Error adding ICE candidate:", err)
 	}
 }
 
 func main() {
-	client, err := NewClient("ws://localhost:8080/ws")
+	signalingURL := flag.String("signaling", "ws://localhost:8080/ws", "signaling server URL (ws://, wss://, http:// or https://)")
+	iceConfigPath := flag.String("ice-config", "", "path to a JSON file listing STUN/TURN servers (defaults to Google STUN if absent)")
+	flag.Parse()
+
+	iceConfig := loadICEConfig(*iceConfigPath)
+
+	signaling, err := newSignaling(*signalingURL)
+	if err != nil {
+		log.Fatal("Error setting up signaling:", err)
+	}
+
+	client, err := NewClient(signaling, iceConfig)
 	if err != nil {
 		log.Fatal("Error connecting to server:", err)
 	}
 
+	client.OnStream(client.handleIncomingFile)
 	go client.handleIncomingMessages()
 
 	fmt.Println("P2P CLI Client")
-	fmt.Println("Available commands: register, connect <peerId>, send <peerId> <message>, exit")
+	fmt.Println("Available commands: register, connect <peerId>, send <peerId> <message>, sendfile <peerId> <path>, recvfile <peerId> <path>, join <room>, leave <room>, broadcast <room> <message>, roster, exit")
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -251,10 +429,50 @@ func main() {
 				continue
 			}
 			client.SendMessage(parts[1], parts[2])
+		case "sendfile":
+			if len(parts) < 3 {
+				fmt.Println("Usage: sendfile <peerId> <path>")
+				continue
+			}
+			go func(peerID, path string) {
+				if err := client.SendFile(peerID, path); err != nil {
+					fmt.Println("Error sending file:", err)
+				}
+			}(parts[1], parts[2])
+		case "recvfile":
+			if len(parts) < 3 {
+				fmt.Println("Usage: recvfile <peerId> <path>")
+				continue
+			}
+			client.ExpectFile(parts[1], parts[2])
+			fmt.Printf("Will save the next file from %s to %s\n", parts[1], parts[2])
+		case "join":
+			if len(parts) < 2 {
+				fmt.Println("Usage: join <room>")
+				continue
+			}
+			client.JoinRoom(parts[1])
+		case "leave":
+			if len(parts) < 2 {
+				fmt.Println("Usage: leave <room>")
+				continue
+			}
+			client.LeaveRoom(parts[1])
+		case "broadcast":
+			if len(parts) < 3 {
+				fmt.Println("Usage: broadcast <room> <message>")
+				continue
+			}
+			client.Broadcast(parts[1], parts[2])
+		case "roster":
+			client.PrintRoster()
 		case "exit":
+			if err := client.Close(); err != nil {
+				fmt.Println("Error closing client:", err)
+			}
 			return
 		default:
-			fmt.Println("Unknown command. Available commands: register, connect, send, exit")
+			fmt.Println("Unknown command. Available commands: register, connect, send, sendfile, recvfile, join, leave, broadcast, roster, exit")
 		}
 	}
 }
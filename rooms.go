@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// JoinRoom asks the signaling server to add us to room. The server
+// responds with a "joined" message listing the room's current members,
+// which handleJoined uses to establish the mesh.
+func (c *Client) JoinRoom(room string) {
+	payload, err := json.Marshal(struct {
+		Room string `json:"room"`
+	}{Room: room})
+	if err != nil {
+		log.Println("Error marshalling join request:", err)
+		return
+	}
+
+	if err := c.signaling.Send(Message{Type: "join", Payload: payload}); err != nil {
+		log.Println("Error sending join request:", err)
+	}
+}
+
+// LeaveRoom asks the signaling server to remove us from room. Existing
+// peer connections are left up, since a peer may still be reachable
+// through another shared room or a direct connect.
+func (c *Client) LeaveRoom(room string) {
+	payload, err := json.Marshal(struct {
+		Room string `json:"room"`
+	}{Room: room})
+	if err != nil {
+		log.Println("Error marshalling leave request:", err)
+		return
+	}
+
+	if err := c.signaling.Send(Message{Type: "leave", Payload: payload}); err != nil {
+		log.Println("Error sending leave request:", err)
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.rooms, room)
+	c.mu.Unlock()
+}
+
+// Broadcast sends message over the open data channel to every peer we
+// believe is a member of room.
+func (c *Client) Broadcast(room, message string) {
+	c.mu.RLock()
+	members := make([]string, 0, len(c.rooms[room]))
+	for peerID := range c.rooms[room] {
+		members = append(members, peerID)
+	}
+	inRoom := c.rooms[room] != nil
+	c.mu.RUnlock()
+
+	if !inRoom {
+		fmt.Printf("Not a member of room %s\n", room)
+		return
+	}
+
+	for _, peerID := range members {
+		c.SendMessage(peerID, message)
+	}
+}
+
+// PrintRoster prints the ICE and data channel state of every peer
+// connection we currently hold.
+func (c *Client) PrintRoster() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.peerConns) == 0 {
+		fmt.Println("No peer connections")
+		return
+	}
+
+	for peerID, peerConnection := range c.peerConns {
+		dataChannelState := "none"
+		if dataChannel, ok := c.dataChannels[peerID]; ok {
+			dataChannelState = dataChannel.ReadyState().String()
+		}
+		fmt.Printf("%s: ice=%s data-channel=%s\n", peerID, peerConnection.ICEConnectionState(), dataChannelState)
+	}
+}
+
+// ensureConnectedTo opens a peer connection to peerID unless one
+// already exists or is already being dialed, so a peer shared between
+// two rooms (or a room and a direct connect) is only ever connected to
+// once even when two callers race to reach the same peerID.
+func (c *Client) ensureConnectedTo(peerID string) {
+	c.mu.Lock()
+	_, connected := c.peerConns[peerID]
+	_, connecting := c.connecting[peerID]
+	if connected || connecting {
+		c.mu.Unlock()
+		return
+	}
+	c.connecting[peerID] = struct{}{}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.connecting, peerID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.ConnectToPeer(peerID); err != nil {
+		log.Println("Error connecting to peer", peerID, ":", err)
+	}
+}
+
+// handleJoined processes the server's response to our own JoinRoom
+// call: it records the room's current members and, for each one we
+// don't already have a connection to, decides who offers using peerID
+// ordering so both sides agree without negotiation (lower peerID
+// always initiates, avoiding signaling glare).
+func (c *Client) handleJoined(room string, members []string) {
+	c.mu.Lock()
+	if c.rooms[room] == nil {
+		c.rooms[room] = make(map[string]struct{})
+	}
+	for _, peerID := range members {
+		c.rooms[room][peerID] = struct{}{}
+	}
+	c.mu.Unlock()
+
+	for _, peerID := range members {
+		if c.peerID < peerID {
+			c.ensureConnectedTo(peerID)
+		}
+	}
+
+	fmt.Printf("Joined room %s with %d member(s)\n", room, len(members))
+}
+
+// handlePeerJoined processes notification that peerID joined a room we
+// are already a member of.
+func (c *Client) handlePeerJoined(room, peerID string) {
+	c.mu.Lock()
+	if c.rooms[room] == nil {
+		c.rooms[room] = make(map[string]struct{})
+	}
+	c.rooms[room][peerID] = struct{}{}
+	c.mu.Unlock()
+
+	if c.peerID < peerID {
+		c.ensureConnectedTo(peerID)
+	}
+
+	fmt.Printf("Peer %s joined room %s\n", peerID, room)
+}
+
+// handlePeerLeft processes notification that peerID left a room we are
+// a member of. The peer connection itself is left alone here; teardown
+// is driven by connection state, not room membership.
+func (c *Client) handlePeerLeft(room, peerID string) {
+	c.mu.Lock()
+	if members, ok := c.rooms[room]; ok {
+		delete(members, peerID)
+	}
+	c.mu.Unlock()
+
+	fmt.Printf("Peer %s left room %s\n", peerID, room)
+}
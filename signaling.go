@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Signaling abstracts the transport used to exchange Messages with the
+// signaling server. NewClient is built against this interface rather
+// than a concrete websocket connection so alternative transports (an
+// HTTP long-poll signaler for restrictive networks, an in-process
+// channel signaler for tests) can be plugged in via --signaling.
+type Signaling interface {
+	Send(Message) error
+	Recv() (Message, error)
+	Close() error
+}
+
+// newSignaling picks a Signaling implementation based on rawURL's
+// scheme: ws(s) dials a websocket, http(s) uses long-polling.
+func newSignaling(rawURL string) (Signaling, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signaling URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		return NewWebsocketSignaling(rawURL)
+	case "http", "https":
+		return NewHTTPSignaling(rawURL), nil
+	default:
+		return nil, fmt.Errorf("unsupported signaling scheme %q (want ws, wss, http or https)", u.Scheme)
+	}
+}
+
+// WebsocketSignaling is the default Signaling, backed by a
+// gorilla/websocket connection to the signaling server.
+type WebsocketSignaling struct {
+	conn *websocket.Conn
+}
+
+func NewWebsocketSignaling(url string) (*WebsocketSignaling, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &WebsocketSignaling{conn: conn}, nil
+}
+
+func (s *WebsocketSignaling) Send(msg Message) error {
+	return s.conn.WriteJSON(msg)
+}
+
+func (s *WebsocketSignaling) Recv() (Message, error) {
+	var msg Message
+	err := s.conn.ReadJSON(&msg)
+	return msg, err
+}
+
+func (s *WebsocketSignaling) Close() error {
+	return s.conn.Close()
+}
+
+// HTTPSignaling is a fallback Signaling for networks that block
+// websocket upgrades. It POSTs outgoing messages to <baseURL>/send and
+// long-polls <baseURL>/poll for incoming ones, mirroring the
+// discovery/offer channel pattern from the dennwc/dom webrtc example.
+type HTTPSignaling struct {
+	baseURL string
+	client  *http.Client
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func NewHTTPSignaling(baseURL string) *HTTPSignaling {
+	return &HTTPSignaling{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 35 * time.Second},
+		closed:  make(chan struct{}),
+	}
+}
+
+func (s *HTTPSignaling) Send(msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.baseURL+"/send", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signaling server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Recv long-polls /poll until a message arrives, the server reports no
+// new message (HTTP 204, in which case it polls again immediately) or
+// the signaler is closed.
+func (s *HTTPSignaling) Recv() (Message, error) {
+	for {
+		select {
+		case <-s.closed:
+			return Message{}, fmt.Errorf("signaling closed")
+		default:
+		}
+
+		resp, err := s.client.Get(s.baseURL + "/poll")
+		if err != nil {
+			return Message{}, err
+		}
+
+		if resp.StatusCode == http.StatusNoContent {
+			resp.Body.Close()
+			continue
+		}
+
+		var msg Message
+		err = json.NewDecoder(resp.Body).Decode(&msg)
+		resp.Body.Close()
+		if err != nil {
+			return Message{}, err
+		}
+		return msg, nil
+	}
+}
+
+func (s *HTTPSignaling) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// fakeSignaling is an in-process Signaling that relays messages directly
+// to a peer's fakeSignaling instead of going over a socket, so the
+// offer/answer/ICE flow can be unit-tested without a real signaling
+// server.
+type fakeSignaling struct {
+	peerID string
+	recvCh chan Message
+	relay  func(msg Message)
+}
+
+func newFakeSignalingPair(peerA, peerB string) (*fakeSignaling, *fakeSignaling) {
+	a := &fakeSignaling{peerID: peerA, recvCh: make(chan Message, 16)}
+	b := &fakeSignaling{peerID: peerB, recvCh: make(chan Message, 16)}
+	a.relay = func(msg Message) { relayMessage(msg, peerA, b.recvCh) }
+	b.relay = func(msg Message) { relayMessage(msg, peerB, a.recvCh) }
+	return a, b
+}
+
+// relayMessage mimics what the real signaling server does: it strips the
+// "target" field and stamps the message with a "source" field so the
+// receiving client knows who sent it.
+func relayMessage(msg Message, source string, to chan Message) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Payload, &fields); err != nil {
+		return
+	}
+	delete(fields, "target")
+	fields["source"], _ = json.Marshal(source)
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	to <- Message{Type: msg.Type, Payload: payload}
+}
+
+func (s *fakeSignaling) Send(msg Message) error {
+	s.relay(msg)
+	return nil
+}
+
+func (s *fakeSignaling) Recv() (Message, error) {
+	msg, ok := <-s.recvCh
+	if !ok {
+		return Message{}, io.EOF
+	}
+	return msg, nil
+}
+
+func (s *fakeSignaling) Close() error {
+	close(s.recvCh)
+	return nil
+}
+
+// TestOfferAnswerICEFlow drives two Clients through ConnectToPeer end to
+// end over a fakeSignaling pair, checking that the offer/answer exchange
+// and ICE candidate forwarding actually bring up a usable data channel.
+func TestOfferAnswerICEFlow(t *testing.T) {
+	sigA, sigB := newFakeSignalingPair("a", "b")
+
+	clientA, err := NewClient(sigA, defaultICEConfig())
+	if err != nil {
+		t.Fatalf("NewClient(a): %v", err)
+	}
+	clientA.peerID = "a"
+
+	clientB, err := NewClient(sigB, defaultICEConfig())
+	if err != nil {
+		t.Fatalf("NewClient(b): %v", err)
+	}
+	clientB.peerID = "b"
+
+	go clientA.handleIncomingMessages()
+	go clientB.handleIncomingMessages()
+	defer clientA.Close()
+	defer clientB.Close()
+
+	if err := clientA.ConnectToPeer("b"); err != nil {
+		t.Fatalf("ConnectToPeer: %v", err)
+	}
+
+	deadline := time.After(10 * time.Second)
+	for {
+		clientA.mu.RLock()
+		_, open := clientA.streams["b"]
+		clientA.mu.RUnlock()
+		if open {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for data channel to open")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	clientA.SendMessage("b", fmt.Sprintf("hello at %s", time.Now().Format(time.RFC3339)))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v3"
+)
+
+// webrtcNetAddr implements net.Addr for a peer identified by its
+// signaling peer ID rather than an IP/port pair.
+type webrtcNetAddr struct {
+	peerID string
+}
+
+func (a webrtcNetAddr) Network() string { return "webrtc" }
+func (a webrtcNetAddr) String() string  { return a.peerID }
+
+// Backpressure watermarks for throttle: writers pause once the SCTP
+// send buffer grows past bufferedAmountHighWaterMark and resume once
+// pion reports it has drained below bufferedAmountLowWaterMark.
+const (
+	bufferedAmountHighWaterMark uint64 = 1 << 20   // 1 MiB
+	bufferedAmountLowWaterMark  uint64 = 256 << 10 // 256 KiB
+)
+
+// webrtcConn adapts a detached data channel to net.Conn so callers can
+// pipe arbitrary byte streams over it (file transfer, tar, SFTP-like
+// protocols) instead of being limited to SendText.
+type webrtcConn struct {
+	datachannel.ReadWriteCloser
+	dataChannel *webrtc.DataChannel
+	peerID      string
+	onClose     func()
+	lowCh       chan struct{}
+}
+
+// throttle blocks while the data channel's SCTP send buffer is above
+// bufferedAmountHighWaterMark, giving callers backpressure on Write
+// without relying on the detached stream to apply it for them.
+func (w *webrtcConn) throttle() {
+	for w.dataChannel.BufferedAmount() > bufferedAmountHighWaterMark {
+		<-w.lowCh
+	}
+}
+
+func (w *webrtcConn) LocalAddr() net.Addr  { return webrtcNetAddr{peerID: "self"} }
+func (w *webrtcConn) RemoteAddr() net.Addr { return webrtcNetAddr{peerID: w.peerID} }
+
+// SetDeadline, SetReadDeadline and SetWriteDeadline are no-ops: the
+// detached SCTP stream pion hands back doesn't expose deadline control
+// through datachannel.ReadWriteCloser.
+func (w *webrtcConn) SetDeadline(t time.Time) error      { return nil }
+func (w *webrtcConn) SetReadDeadline(t time.Time) error  { return nil }
+func (w *webrtcConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (w *webrtcConn) Close() error {
+	err := w.ReadWriteCloser.Close()
+	if w.onClose != nil {
+		w.onClose()
+	}
+	return err
+}
+
+// wrapStream wraps a detached data channel as a net.Conn and tracks it
+// against peerID so the peer connection can be torn down once every
+// stream to that peer has closed.
+func (c *Client) wrapStream(raw datachannel.ReadWriteCloser, dataChannel *webrtc.DataChannel, peerID string) net.Conn {
+	c.mu.Lock()
+	c.streamCounts[peerID]++
+	c.mu.Unlock()
+
+	wc := &webrtcConn{
+		ReadWriteCloser: raw,
+		dataChannel:     dataChannel,
+		peerID:          peerID,
+		lowCh:           make(chan struct{}, 1),
+	}
+	wc.onClose = func() {
+		c.closeStream(dataChannel, peerID)
+	}
+
+	dataChannel.SetBufferedAmountLowThreshold(bufferedAmountLowWaterMark)
+	dataChannel.OnBufferedAmountLow(func() {
+		select {
+		case wc.lowCh <- struct{}{}:
+		default:
+		}
+	})
+
+	return wc
+}
+
+// closeStream closes dataChannel and, once the last open stream to
+// peerID has closed, also closes and forgets the underlying peer
+// connection.
+func (c *Client) closeStream(dataChannel *webrtc.DataChannel, peerID string) {
+	dataChannel.Close()
+
+	c.mu.Lock()
+	c.streamCounts[peerID]--
+	last := c.streamCounts[peerID] <= 0
+	if last {
+		delete(c.streamCounts, peerID)
+	}
+	var peerConnection *webrtc.PeerConnection
+	if last {
+		peerConnection = c.peerConns[peerID]
+		delete(c.peerConns, peerID)
+	}
+	c.mu.Unlock()
+
+	if peerConnection != nil {
+		peerConnection.Close()
+	}
+}
+
+// OpenStream opens a new detached data channel to peerID, which must
+// already have an established PeerConnection, and returns it as a
+// net.Conn once the channel is open.
+func (c *Client) OpenStream(peerID string) (net.Conn, error) {
+	c.mu.RLock()
+	peerConnection, ok := c.peerConns[peerID]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no peer connection to %s", peerID)
+	}
+
+	dataChannel, err := peerConnection.CreateDataChannel("stream", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	connCh := make(chan net.Conn, 1)
+	dataChannel.OnOpen(func() {
+		raw, err := dataChannel.Detach()
+		if err != nil {
+			log.Println("Error detaching stream data channel:", err)
+			connCh <- nil
+			return
+		}
+		connCh <- c.wrapStream(raw, dataChannel, peerID)
+	})
+
+	conn := <-connCh
+	if conn == nil {
+		return nil, fmt.Errorf("failed to detach stream data channel to %s", peerID)
+	}
+	return conn, nil
+}
+
+// OnStream registers a callback invoked whenever a remote peer opens a
+// new stream data channel to us.
+func (c *Client) OnStream(fn func(peerID string, conn net.Conn)) {
+	c.mu.Lock()
+	c.onStream = fn
+	c.mu.Unlock()
+}
+
+// setupIncomingStream detaches a remotely-opened stream data channel
+// once it's ready and hands it to the registered OnStream callback, if
+// any.
+func (c *Client) setupIncomingStream(dataChannel *webrtc.DataChannel, peerID string) {
+	dataChannel.OnOpen(func() {
+		raw, err := dataChannel.Detach()
+		if err != nil {
+			log.Println("Error detaching incoming stream data channel:", err)
+			return
+		}
+
+		conn := c.wrapStream(raw, dataChannel, peerID)
+
+		c.mu.RLock()
+		onStream := c.onStream
+		c.mu.RUnlock()
+		if onStream != nil {
+			onStream(peerID, conn)
+		}
+	})
+}